@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io"
+	"strings"
+
+	"github.com/CthulhuDen/drone-s3/s3fs"
+	"github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+)
+
+// execCopy handles the case where both Source and Target are s3:// URLs,
+// streaming every matching object from the source bucket/prefix straight
+// to the target bucket/prefix through the same credential chain and
+// client used everywhere else in Exec, without staging anything locally.
+func (p *Plugin) execCopy(client *s3.S3) error {
+	srcBucket, srcPrefix, err := s3fs.ParsePath(p.Source)
+	if err != nil {
+		return err
+	}
+
+	dstBucket, dstPrefix, err := s3fs.ParsePath(p.Target)
+	if err != nil {
+		return err
+	}
+
+	fs := s3fs.New(client)
+
+	var items []*s3.Object
+	err = client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: &srcBucket,
+		Prefix: &srcPrefix,
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		items = append(items, page.Contents...)
+		return true
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err,
+			"bucket": srcBucket,
+			"prefix": srcPrefix,
+		}).Error("Cannot list source S3 bucket")
+		return err
+	}
+
+	for _, item := range items {
+		if excluded(*item.Key, p.Exclude) {
+			continue
+		}
+
+		dstKey := dstPrefix + strings.TrimPrefix(*item.Key, srcPrefix)
+
+		log.WithFields(log.Fields{
+			"source": s3fs.Scheme + srcBucket + "/" + *item.Key,
+			"target": s3fs.Scheme + dstBucket + "/" + dstKey,
+		}).Info("Copying S3 object")
+
+		if p.DryRun {
+			continue
+		}
+
+		if err := copyOne(fs, srcBucket, *item.Key, dstBucket, dstKey); err != nil {
+			log.WithFields(log.Fields{
+				"error":  err,
+				"source": *item.Key,
+				"target": dstKey,
+			}).Error("Could not copy S3 object")
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyOne(fs *s3fs.FS, srcBucket, srcKey, dstBucket, dstKey string) error {
+	r, err := fs.Open(s3fs.Scheme + srcBucket + "/" + srcKey)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := fs.Create(s3fs.Scheme + dstBucket + "/" + dstKey)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	log "github.com/sirupsen/logrus"
+)
+
+// buildCredentials assembles a credentials.ChainProvider honoring, in
+// order: static keys, AWS_WEB_IDENTITY_TOKEN_FILE (EKS/IRSA), a named
+// Profile from ~/.aws/credentials, ECS container credentials, and finally
+// EC2 IMDSv2. The existing AssumeRole/UserRoleArn role assumption is
+// chained on top of whatever base credentials this resolves to, unless
+// AssumeRole is the same role already obtained via web identity, in which
+// case it is not assumed a second time.
+func buildCredentials(p *Plugin) (*credentials.Credentials, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	var providers []credentials.Provider
+
+	if p.Key == "" && p.AssumeRole == "" && p.Profile == "" && webIdentityTokenFile(p) == "" {
+		log.Warn("AWS Key and/or Secret not provided (falling back to ec2 instance profile)")
+	}
+
+	if p.Key != "" && p.Secret != "" {
+		providers = append(providers, &credentials.StaticProvider{
+			Value: credentials.Value{AccessKeyID: p.Key, SecretAccessKey: p.Secret},
+		})
+	}
+
+	// assumedViaWebIdentity is set when AssumeRole was already consumed as
+	// the web identity role ARN, so it must not be chained a second time
+	// below: re-running AssumeRole on the session it just produced fails
+	// the role's trust policy, which checks the caller's original identity.
+	assumedViaWebIdentity := false
+	if tokenFile := webIdentityTokenFile(p); tokenFile != "" {
+		if roleARN := webIdentityRoleARN(p); roleARN != "" {
+			providers = append(providers, stscreds.NewWebIdentityRoleProvider(
+				sts.New(sess), roleARN, p.AssumeRoleSessionName, tokenFile,
+			))
+			if roleARN == p.AssumeRole {
+				assumedViaWebIdentity = true
+			}
+		}
+	}
+
+	if p.Profile != "" {
+		providers = append(providers, &credentials.SharedCredentialsProvider{Profile: p.Profile})
+	}
+
+	if os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "" {
+		providers = append(providers, defaults.RemoteCredProvider(*sess.Config, defaults.Handlers()))
+	}
+
+	providers = append(providers, &ec2rolecreds.EC2RoleProvider{Client: ec2metadata.New(sess)})
+
+	creds := credentials.NewChainCredentials(providers)
+
+	if p.AssumeRole != "" && !assumedViaWebIdentity {
+		creds = assumeRoleCredentials(sess, creds, p)
+	}
+
+	return creds, nil
+}
+
+func webIdentityTokenFile(p *Plugin) string {
+	if p.WebIdentityTokenFile != "" {
+		return p.WebIdentityTokenFile
+	}
+	return os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+}
+
+func webIdentityRoleARN(p *Plugin) string {
+	if p.AssumeRole != "" {
+		return p.AssumeRole
+	}
+	return os.Getenv("AWS_ROLE_ARN")
+}
+
+// assumeRoleCredentials chains an AssumeRole call for p.AssumeRole on top
+// of base, honoring ExternalID and AssumeRoleDurationSeconds.
+func assumeRoleCredentials(sess *session.Session, base *credentials.Credentials, p *Plugin) *credentials.Credentials {
+	return stscreds.NewCredentials(sess, p.AssumeRole, func(opts *stscreds.AssumeRoleProvider) {
+		opts.Client = sts.New(sess, &aws.Config{Credentials: base})
+		opts.RoleSessionName = p.AssumeRoleSessionName
+		opts.Duration = assumeRoleDuration(p)
+		if p.ExternalID != "" {
+			opts.ExternalID = &p.ExternalID
+		}
+	})
+}
+
+func assumeRoleDuration(p *Plugin) time.Duration {
+	if p.AssumeRoleDurationSeconds > 0 {
+		return time.Duration(p.AssumeRoleDurationSeconds) * time.Second
+	}
+	return time.Hour
+}
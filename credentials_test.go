@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWebIdentityTokenFile(t *testing.T) {
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", "/var/run/secrets/env-token")
+
+	if got := webIdentityTokenFile(&Plugin{WebIdentityTokenFile: "/explicit/token"}); got != "/explicit/token" {
+		t.Errorf("explicit field should win over env var, got %q", got)
+	}
+
+	if got := webIdentityTokenFile(&Plugin{}); got != "/var/run/secrets/env-token" {
+		t.Errorf("expected fallback to AWS_WEB_IDENTITY_TOKEN_FILE, got %q", got)
+	}
+
+	os.Unsetenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	if got := webIdentityTokenFile(&Plugin{}); got != "" {
+		t.Errorf("expected empty string with nothing configured, got %q", got)
+	}
+}
+
+func TestWebIdentityRoleARN(t *testing.T) {
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::111111111111:role/env-role")
+
+	if got := webIdentityRoleARN(&Plugin{AssumeRole: "arn:aws:iam::222222222222:role/explicit"}); got != "arn:aws:iam::222222222222:role/explicit" {
+		t.Errorf("AssumeRole field should win over env var, got %q", got)
+	}
+
+	if got := webIdentityRoleARN(&Plugin{}); got != "arn:aws:iam::111111111111:role/env-role" {
+		t.Errorf("expected fallback to AWS_ROLE_ARN, got %q", got)
+	}
+}
+
+func TestWebIdentityRoleARNMatchesAssumeRole(t *testing.T) {
+	// when WebIdentityTokenFile and AssumeRole are set together, AssumeRole
+	// is used as the web identity role ARN, and buildCredentials must skip
+	// assuming it a second time - this just documents that webIdentityRoleARN
+	// legitimately returns the same value as p.AssumeRole in that setup.
+	p := &Plugin{
+		AssumeRole:           "arn:aws:iam::333333333333:role/irsa-role",
+		WebIdentityTokenFile: "/var/run/secrets/token",
+	}
+	if got := webIdentityRoleARN(p); got != p.AssumeRole {
+		t.Errorf("webIdentityRoleARN() = %q, want it to match AssumeRole %q", got, p.AssumeRole)
+	}
+}
+
+func TestAssumeRoleDuration(t *testing.T) {
+	if got := assumeRoleDuration(&Plugin{}); got != time.Hour {
+		t.Errorf("default duration = %v, want 1h", got)
+	}
+
+	p := &Plugin{AssumeRoleDurationSeconds: 1800}
+	if got := assumeRoleDuration(p); got != 30*time.Minute {
+		t.Errorf("assumeRoleDuration() = %v, want 30m", got)
+	}
+}
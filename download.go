@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/mattn/go-zglob"
+	log "github.com/sirupsen/logrus"
+)
+
+// downloadAll lists every object under p.Target, drops keys matching any
+// p.Exclude glob pattern, and downloads the surviving objects with a
+// bounded worker pool and ranged, parallel part GETs.
+func (p *Plugin) downloadAll(client *s3.S3) error {
+	targetDir := strings.TrimPrefix(filepath.ToSlash(p.Target), "/")
+	log.WithFields(log.Fields{
+		"bucket": p.Bucket,
+		"dir":    targetDir,
+	}).Info("Listing S3 directory")
+
+	var items []*s3.Object
+	err := client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: &p.Bucket,
+		Prefix: &targetDir,
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		items = append(items, page.Contents...)
+		return true
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err,
+			"bucket": p.Bucket,
+			"dir":    targetDir,
+		}).Error("Cannot list S3 directory")
+		return err
+	}
+
+	downloader := s3manager.NewDownloaderWithClient(client)
+
+	sem := make(chan struct{}, p.concurrency())
+	errCh := make(chan error, len(items))
+	var pending int
+
+	for _, item := range items {
+		if excluded(*item.Key, p.Exclude) {
+			continue
+		}
+
+		source := resolveSource(targetDir, *item.Key, p.StripPrefix)
+
+		if p.Sync && unchangedLocal(source, item) {
+			log.WithFields(log.Fields{
+				"bucket": p.Bucket,
+				"key":    *item.Key,
+			}).Info("Skipping unchanged object")
+			continue
+		}
+
+		pending++
+		sem <- struct{}{}
+		item := item
+		src := source
+		go func() {
+			defer func() { <-sem }()
+			errCh <- p.downloadOne(client, downloader, item, src)
+		}()
+	}
+
+	var firstErr error
+	for i := 0; i < pending; i++ {
+		if err := <-errCh; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+func (p *Plugin) downloadOne(client *s3.S3, downloader *s3manager.Downloader, item *s3.Object, source string) error {
+	log.WithFields(log.Fields{
+		"bucket": p.Bucket,
+		"key":    *item.Key,
+	}).Info("Getting S3 object")
+
+	if err := os.MkdirAll(filepath.Dir(source), 0o755); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"file":  source,
+		}).Error("Problem creating target directory")
+		return err
+	}
+
+	f, err := os.Create(source)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"file":  source,
+		}).Error("Problem opening file for writing")
+		return err
+	}
+	defer f.Close()
+
+	n, err := downloader.Download(f, &s3.GetObjectInput{
+		Bucket: &p.Bucket,
+		Key:    item.Key,
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err,
+			"bucket": p.Bucket,
+			"key":    *item.Key,
+		}).Error("Cannot get S3 object")
+		return err
+	}
+
+	if item.Size != nil && n != *item.Size {
+		log.WithFields(log.Fields{
+			"file":     source,
+			"expected": *item.Size,
+			"got":      n,
+		}).Error("Downloaded size does not match object Content-Length")
+		return errMismatchedSize(source)
+	}
+
+	return p.verifyChecksum(client, item, source)
+}
+
+// verifyChecksum re-requests the object's x-amz-checksum-* headers (not
+// returned by a plain GetObject) and, if the object was uploaded with one,
+// recomputes it from the downloaded file and compares.
+func (p *Plugin) verifyChecksum(client *s3.S3, item *s3.Object, source string) error {
+	head, err := client.HeadObject(&s3.HeadObjectInput{
+		Bucket:       &p.Bucket,
+		Key:          item.Key,
+		ChecksumMode: aws.String("ENABLED"),
+	})
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "key": *item.Key}).
+			Warn("Could not fetch checksum headers to verify download")
+		return nil
+	}
+
+	algorithm, expected := "", ""
+	switch {
+	case head.ChecksumSHA256 != nil:
+		algorithm, expected = "SHA256", *head.ChecksumSHA256
+	case head.ChecksumCRC32C != nil:
+		algorithm, expected = "CRC32C", *head.ChecksumCRC32C
+	default:
+		return nil
+	}
+
+	actual, err := checksumFile(source, algorithm)
+	if err != nil {
+		return err
+	}
+
+	if base64.StdEncoding.EncodeToString(actual) != expected {
+		log.WithFields(log.Fields{
+			"file":      source,
+			"algorithm": algorithm,
+			"expected":  expected,
+		}).Error("Downloaded object checksum does not match x-amz-checksum header")
+		return errChecksumMismatch(source)
+	}
+
+	return nil
+}
+
+// excluded reports whether key matches any of the given glob patterns.
+func excluded(key string, exclude []string) bool {
+	for _, pattern := range exclude {
+		if ok, _ := zglob.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// unchangedLocal reports whether the local file at path already matches
+// the remote object's size and modification time, so the download can be
+// skipped.
+func unchangedLocal(path string, item *s3.Object) bool {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	if item.Size != nil && stat.Size() != *item.Size {
+		return false
+	}
+
+	if item.LastModified != nil && stat.ModTime().Before(*item.LastModified) {
+		return false
+	}
+
+	// a multipart ETag (containing "-N") can't be recomputed without
+	// knowing the part size used at upload time, so size+modtime alone
+	// has to suffice for those objects.
+	if item.ETag == nil || strings.Contains(*item.ETag, "-") {
+		return true
+	}
+
+	etag, err := localETag(path, 0)
+	if err != nil {
+		return true
+	}
+
+	return etag == *item.ETag
+}
+
+type errMismatchedSize string
+
+func (e errMismatchedSize) Error() string {
+	return "downloaded size mismatch for " + string(e)
+}
+
+type errChecksumMismatch string
+
+func (e errChecksumMismatch) Error() string {
+	return "downloaded checksum mismatch for " + string(e)
+}
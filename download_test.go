@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestExcluded(t *testing.T) {
+	cases := []struct {
+		key     string
+		exclude []string
+		want    bool
+	}{
+		{key: "build/app.log", exclude: []string{"*.log"}, want: false},
+		{key: "build/app.log", exclude: []string{"build/*.log"}, want: true},
+		{key: "build/app.txt", exclude: []string{"build/*.log"}, want: false},
+		{key: "any/path", exclude: nil, want: false},
+	}
+
+	for _, c := range cases {
+		if got := excluded(c.key, c.exclude); got != c.want {
+			t.Errorf("excluded(%q, %v) = %v, want %v", c.key, c.exclude, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import "fmt"
+
+// validateEncryption checks that the encryption-related fields are not
+// mutually exclusive before any request is sent to S3.
+func (p *Plugin) validateEncryption() error {
+	if p.Encryption != "" && p.SSECustomerAlgorithm != "" {
+		return fmt.Errorf("encryption: Encryption and SSECustomerAlgorithm (SSE-C) are mutually exclusive")
+	}
+
+	if p.SSEKMSKeyID != "" && p.Encryption != "aws:kms" {
+		return fmt.Errorf("encryption: SSEKMSKeyID requires Encryption to be \"aws:kms\"")
+	}
+
+	sseC := p.SSECustomerAlgorithm != "" || p.SSECustomerKey != "" || p.SSECustomerKeyMD5 != ""
+	sseCComplete := p.SSECustomerAlgorithm != "" && p.SSECustomerKey != "" && p.SSECustomerKeyMD5 != ""
+	if sseC && !sseCComplete {
+		return fmt.Errorf("encryption: SSECustomerAlgorithm, SSECustomerKey and SSECustomerKeyMD5 must all be set together")
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestValidateEncryption(t *testing.T) {
+	cases := []struct {
+		name    string
+		plugin  Plugin
+		wantErr bool
+	}{
+		{name: "none set", plugin: Plugin{}},
+		{name: "kms only", plugin: Plugin{Encryption: "aws:kms"}},
+		{name: "kms with key id", plugin: Plugin{Encryption: "aws:kms", SSEKMSKeyID: "key-1"}},
+		{name: "key id without kms", plugin: Plugin{Encryption: "AES256", SSEKMSKeyID: "key-1"}, wantErr: true},
+		{name: "key id without encryption", plugin: Plugin{SSEKMSKeyID: "key-1"}, wantErr: true},
+		{
+			name: "complete sse-c",
+			plugin: Plugin{
+				SSECustomerAlgorithm: "AES256",
+				SSECustomerKey:       "base64key",
+				SSECustomerKeyMD5:    "base64md5",
+			},
+		},
+		{name: "partial sse-c", plugin: Plugin{SSECustomerAlgorithm: "AES256"}, wantErr: true},
+		{
+			name: "sse-c and encryption together",
+			plugin: Plugin{
+				Encryption:           "AES256",
+				SSECustomerAlgorithm: "AES256",
+				SSECustomerKey:       "base64key",
+				SSECustomerKeyMD5:    "base64md5",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		err := c.plugin.validateEncryption()
+		if c.wantErr && err == nil {
+			t.Errorf("%s: expected an error, got none", c.name)
+		}
+		if !c.wantErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// manifestEntry describes one object uploaded during this run, written to
+// Plugin.ManifestPath so reviewers can find build artifacts without
+// re-listing the bucket.
+type manifestEntry struct {
+	Key         string `json:"key"`
+	Size        int64  `json:"size"`
+	ETag        string `json:"etag"`
+	ContentType string `json:"contentType"`
+	URL         string `json:"url,omitempty"`
+}
+
+// presignGet returns a GET URL for key valid for Plugin.PresignExpiry.
+func (p *Plugin) presignGet(client *s3.S3, key string) (string, error) {
+	req, _ := client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: &p.Bucket,
+		Key:    &key,
+	})
+	return req.Presign(p.PresignExpiry)
+}
+
+// writeManifest writes entries as a JSON array to path.
+func writeManifest(path string, entries []manifestEntry) error {
+	if entries == nil {
+		entries = []manifestEntry{}
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteManifest(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.json")
+
+	entries := []manifestEntry{
+		{Key: "/builds/app.tar.gz", Size: 1024, ETag: `"abc123"`, ContentType: "application/gzip", URL: "https://example.com/signed"},
+		{Key: "/builds/app.sig", Size: 64, ETag: `"def456"`, ContentType: "application/octet-stream"},
+	}
+
+	if err := writeManifest(path, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []manifestEntry
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("manifest is not valid JSON: %v", err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("got %d entries, want 2", len(got))
+	}
+	if got[0].URL == "" {
+		t.Error("expected first entry to retain its presigned URL")
+	}
+	if got[1].URL != "" {
+		t.Errorf("expected second entry to omit url field, got %q", got[1].URL)
+	}
+
+	// url must be entirely absent from the JSON (omitempty), not merely empty.
+	if strings.Contains(string(data), `"url": ""`) {
+		t.Error("manifest should omit \"url\" rather than emit it empty")
+	}
+}
+
+func TestWriteManifestNilEntries(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.json")
+
+	if err := writeManifest(path, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []manifestEntry
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got == nil {
+		t.Error("writeManifest(nil) should still produce a JSON array, got null")
+	}
+}
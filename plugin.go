@@ -1,7 +1,6 @@
 package main
 
 import (
-	"io"
 	"mime"
 	"os"
 	"path/filepath"
@@ -9,15 +8,13 @@ import (
 	"strings"
 	"time"
 
+	"github.com/CthulhuDen/drone-s3/s3fs"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/mattn/go-zglob"
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/sync/errgroup"
 )
 
 // Plugin defines the S3 plugin parameters.
@@ -30,12 +27,42 @@ type Plugin struct {
 	Bucket                string
 	UserRoleArn           string
 
+	// Profile selects a named profile from the shared ~/.aws/credentials
+	// file, tried after static keys and web identity but before the
+	// ECS/EC2 instance metadata providers.
+	Profile string
+
+	// WebIdentityTokenFile, when set (or read from the
+	// AWS_WEB_IDENTITY_TOKEN_FILE environment variable), enables
+	// EKS/IRSA-style authentication via stscreds.WebIdentityRoleProvider.
+	WebIdentityTokenFile string
+
+	// ExternalID is passed through to the AssumeRole call, for roles that
+	// require it. It is not sent when assuming UserRoleArn.
+	ExternalID string
+
+	// AssumeRoleDurationSeconds overrides the default 1 hour duration of
+	// the AssumeRole credentials. It does not apply to UserRoleArn.
+	AssumeRoleDurationSeconds int64
+
 	// if not "", enable server-side encryption
 	// valid values are:
 	//     AES256
 	//     aws:kms
 	Encryption string
 
+	// SSEKMSKeyID selects the customer master key used when Encryption is
+	// "aws:kms". If empty, the bucket default CMK is used.
+	SSEKMSKeyID string
+
+	// SSECustomerAlgorithm, SSECustomerKey and SSECustomerKeyMD5 enable
+	// server-side encryption with a customer-provided key (SSE-C). All
+	// three must be set together, and are mutually exclusive with
+	// Encryption/SSEKMSKeyID.
+	SSECustomerAlgorithm string
+	SSECustomerKey       string
+	SSECustomerKeyMD5    string
+
 	// us-east-1
 	// us-west-1
 	// us-west-2
@@ -94,6 +121,48 @@ type Plugin struct {
 	Download bool
 	// Dry run without uploading/
 	DryRun bool
+
+	// PartSize is the size, in bytes, of each part of a multipart upload.
+	// Files larger than PartSize are streamed as a multipart upload;
+	// defaults to 64MiB.
+	PartSize int64
+
+	// Concurrency is the number of upload parts sent in parallel.
+	// Defaults to 4.
+	Concurrency int
+
+	// MaxRetries is the number of times a failed part upload is retried
+	// before the multipart upload fails. The failed upload is not aborted;
+	// its state file is kept so a later run can resume it. Defaults to 3.
+	MaxRetries int
+
+	// ChecksumAlgorithm, when set, is sent as an x-amz-checksum-* header
+	// (and as the part Content-MD5 for the MD5 case) so S3 verifies
+	// integrity server-side. One of: CRC32C, SHA256, MD5.
+	ChecksumAlgorithm string
+
+	// Sync, when true, turns on mirror semantics: on upload the target
+	// prefix is listed first so SkipUnchanged and Delete can act on it; on
+	// download, objects whose local copy already matches are skipped.
+	Sync bool
+
+	// Delete, when used with Sync, removes remote keys under Target that
+	// no longer have a matching local file.
+	Delete bool
+
+	// SkipUnchanged, when used with Sync, compares each local file's
+	// S3 ETag-equivalent against the remote object and skips the upload
+	// when they already match.
+	SkipUnchanged bool
+
+	// ManifestPath, when set, is written as a JSON file listing every
+	// object uploaded in this run, including a presigned GET URL if
+	// PresignExpiry is set.
+	ManifestPath string
+
+	// PresignExpiry is how long the presigned URLs written to the
+	// manifest stay valid. 0 (the default) disables presigning.
+	PresignExpiry time.Duration
 }
 
 // Exec runs the plugin
@@ -103,6 +172,16 @@ func (p *Plugin) Exec() error {
 		p.Target = p.Target[1:]
 	}
 
+	if err := p.validateEncryption(); err != nil {
+		log.WithError(err).Error("Invalid encryption configuration")
+		return err
+	}
+
+	if err := p.validatePartSize(); err != nil {
+		log.WithError(err).Error("Invalid PartSize configuration")
+		return err
+	}
+
 	// create the client
 	conf := &aws.Config{
 		Region:           aws.String(p.Region),
@@ -111,13 +190,12 @@ func (p *Plugin) Exec() error {
 		S3ForcePathStyle: aws.Bool(p.PathStyle),
 	}
 
-	if p.Key != "" && p.Secret != "" {
-		conf.Credentials = credentials.NewStaticCredentials(p.Key, p.Secret, "")
-	} else if p.AssumeRole != "" {
-		conf.Credentials = assumeRole(p.AssumeRole, p.AssumeRoleSessionName)
-	} else {
-		log.Warn("AWS Key and/or Secret not provided (falling back to ec2 instance profile)")
+	creds, err := buildCredentials(p)
+	if err != nil {
+		log.WithError(err).Error("could not build credential chain")
+		return err
 	}
+	conf.Credentials = creds
 
 	var client *s3.S3
 	sess, err := session.NewSession(conf)
@@ -138,75 +216,12 @@ func (p *Plugin) Exec() error {
 		client = s3.New(sess)
 	}
 
-	if p.Download {
-		targetDir := strings.TrimPrefix(filepath.ToSlash(p.Target), "/")
-		log.WithFields(log.Fields{
-			"bucket": p.Bucket,
-			"dir":    targetDir,
-		}).Info("Listing S3 directory")
-
-		list, err := client.ListObjectsV2(&s3.ListObjectsV2Input{
-			Bucket: &p.Bucket,
-			Prefix: &targetDir,
-		})
-		if err != nil {
-			log.WithFields(log.Fields{
-				"error":  err,
-				"bucket": p.Bucket,
-				"dir":    targetDir,
-			}).Error("Cannot list S3 directory")
-			return err
-		}
-
-		g := errgroup.Group{}
-
-		for _, item := range list.Contents {
-			log.WithFields(log.Fields{
-				"bucket": p.Bucket,
-				"key":    *item.Key,
-			}).Info("Getting S3 object")
-
-			item := item
-			g.Go(func() error {
-				obj, err := client.GetObject(&s3.GetObjectInput{
-					Bucket: &p.Bucket,
-					Key:    item.Key,
-				})
-				if err != nil {
-					log.WithFields(log.Fields{
-						"error":  err,
-						"bucket": p.Bucket,
-						"key":    *item.Key,
-					}).Error("Cannot get S3 object")
-					return err
-				}
-
-				source := resolveSource(targetDir, *item.Key, p.StripPrefix)
-
-				f, err := os.Create(source)
-				if err != nil {
-					log.WithFields(log.Fields{
-						"error": err,
-						"file":  source,
-					}).Error("Problem opening file for writing")
-					return err
-				}
-				defer f.Close()
-
-				_, err = io.Copy(f, obj.Body)
-				if err != nil {
-					log.WithFields(log.Fields{
-						"error": err,
-						"file":  source,
-					}).Error("Failed to write file")
-					return err
-				}
-
-				return nil
-			})
-		}
+	if s3fs.IsPath(p.Source) && s3fs.IsPath(p.Target) {
+		return p.execCopy(client)
+	}
 
-		return g.Wait()
+	if p.Download {
+		return p.downloadAll(client)
 	}
 
 	// find the bucket
@@ -224,6 +239,42 @@ func (p *Plugin) Exec() error {
 		return err
 	}
 
+	var remoteETags map[string]string
+	uploadedKeys := map[string]bool{}
+	var manifestEntries []manifestEntry
+	if p.Sync {
+		// list using the same key shape resolveKey gives uploaded
+		// objects (it always prepends a leading "/"), or the listing
+		// would never match what this plugin itself just uploaded.
+		// The trailing "/" keeps sibling prefixes (e.g. "/builds/1234" for
+		// Target "builds/123") out of the listing, and so out of Delete.
+		syncPrefix := resolveKey(p.Target, "", p.StripPrefix)
+		if !strings.HasSuffix(syncPrefix, "/") {
+			syncPrefix += "/"
+		}
+		remoteETags, err = listRemoteETags(client, p.Bucket, syncPrefix)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("Could not list S3 bucket for sync")
+			return err
+		}
+
+		// files matched by Exclude are still present locally, they are
+		// just deliberately not uploaded. Mark their keys as present so
+		// Sync+Delete never treats them as orphaned and removes them.
+		excludedMatches, err := excludedMatches(p.Exclude)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("Could not match excluded files")
+			return err
+		}
+		for _, match := range excludedMatches {
+			uploadedKeys[resolveKey(p.Target, match, p.StripPrefix)] = true
+		}
+	}
+
 	for _, match := range matches {
 
 		stat, err := os.Stat(match)
@@ -237,6 +288,16 @@ func (p *Plugin) Exec() error {
 		}
 
 		target := resolveKey(p.Target, match, p.StripPrefix)
+		uploadedKeys[target] = true
+
+		if p.Sync && p.SkipUnchanged && unchanged(match, remoteETags[target], p.PartSize) {
+			log.WithFields(log.Fields{
+				"name":   match,
+				"bucket": p.Bucket,
+				"target": target,
+			}).Info("Skipping unchanged file")
+			continue
+		}
 
 		contentType := matchExtension(match, p.ContentType)
 		contentEncoding := matchExtension(match, p.ContentEncoding)
@@ -263,18 +324,7 @@ func (p *Plugin) Exec() error {
 			continue
 		}
 
-		f, err := os.Open(match)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"error": err,
-				"file":  match,
-			}).Error("Problem opening file")
-			return err
-		}
-		defer f.Close()
-
 		putObjectInput := &s3.PutObjectInput{
-			Body:   f,
 			Bucket: &(p.Bucket),
 			Key:    &target,
 			ACL:    &(p.Access),
@@ -296,11 +346,21 @@ func (p *Plugin) Exec() error {
 			putObjectInput.ServerSideEncryption = aws.String(p.Encryption)
 		}
 
+		if p.SSEKMSKeyID != "" {
+			putObjectInput.SSEKMSKeyId = aws.String(p.SSEKMSKeyID)
+		}
+
+		if p.SSECustomerAlgorithm != "" {
+			putObjectInput.SSECustomerAlgorithm = aws.String(p.SSECustomerAlgorithm)
+			putObjectInput.SSECustomerKey = aws.String(p.SSECustomerKey)
+			putObjectInput.SSECustomerKeyMD5 = aws.String(p.SSECustomerKeyMD5)
+		}
+
 		if p.StorageClass != "" {
 			putObjectInput.StorageClass = &(p.StorageClass)
 		}
 
-		_, err = client.PutObject(putObjectInput)
+		etag, err := p.uploadFile(client, match, target, putObjectInput)
 
 		if err != nil {
 			log.WithFields(log.Fields{
@@ -312,7 +372,43 @@ func (p *Plugin) Exec() error {
 
 			return err
 		}
-		f.Close()
+
+		if p.ManifestPath != "" {
+			entry := manifestEntry{
+				Key:         target,
+				Size:        stat.Size(),
+				ETag:        etag,
+				ContentType: contentType,
+			}
+			if p.PresignExpiry > 0 {
+				url, err := p.presignGet(client, target)
+				if err != nil {
+					log.WithError(err).Warn("Could not presign uploaded object URL")
+				} else {
+					entry.URL = url
+				}
+			}
+			manifestEntries = append(manifestEntries, entry)
+		}
+	}
+
+	if p.ManifestPath != "" {
+		if err := writeManifest(p.ManifestPath, manifestEntries); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"path":  p.ManifestPath,
+			}).Error("Could not write upload manifest")
+			return err
+		}
+	}
+
+	if p.Sync && p.Delete && !p.DryRun {
+		if err := deleteOrphaned(client, p.Bucket, remoteETags, uploadedKeys); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("Could not delete orphaned S3 objects")
+			return err
+		}
 	}
 
 	return nil
@@ -354,6 +450,28 @@ func matches(include string, exclude []string) ([]string, error) {
 	return included, nil
 }
 
+// excludedMatches returns every local file matched by any of the exclude
+// Glob patterns, deduplicated. It mirrors the exclusion half of matches,
+// so callers can tell which locally-present files were deliberately left
+// out of an upload (as opposed to simply not existing).
+func excludedMatches(exclude []string) ([]string, error) {
+	seen := map[string]bool{}
+	var files []string
+	for _, pattern := range exclude {
+		matches, err := zglob.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+	return files, nil
+}
+
 func matchExtension(match string, stringMap map[string]string) string {
 	for pattern := range stringMap {
 		matched, err := regexp.MatchString(pattern, match)
@@ -370,19 +488,6 @@ func matchExtension(match string, stringMap map[string]string) string {
 	return ""
 }
 
-func assumeRole(roleArn, roleSessionName string) *credentials.Credentials {
-	client := sts.New(session.New())
-	duration := time.Hour * 1
-	stsProvider := &stscreds.AssumeRoleProvider{
-		Client:          client,
-		Duration:        duration,
-		RoleARN:         roleArn,
-		RoleSessionName: roleSessionName,
-	}
-
-	return credentials.NewCredentials(stsProvider)
-}
-
 // resolveKey is a helper function that returns s3 object key where file present at srcPath is uploaded to.
 // srcPath is assumed to be in forward slash format
 func resolveKey(target, srcPath, stripPrefix string) string {
@@ -0,0 +1,159 @@
+// Package s3fs implements a small virtual filesystem over s3://bucket/key
+// URLs, in the spirit of nsheridan/wkfs/s3. It lets other plugin
+// subsystems read and write objects through the same *s3.S3 client and
+// credential chain that Plugin.Exec already builds, without duplicating
+// session setup.
+package s3fs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// Scheme is the URL scheme recognised by this package.
+const Scheme = "s3://"
+
+// FS is a virtual filesystem backed by a single S3 client.
+type FS struct {
+	client   *s3.S3
+	uploader *s3manager.Uploader
+}
+
+// New returns an FS backed by client.
+func New(client *s3.S3) *FS {
+	return &FS{client: client, uploader: s3manager.NewUploaderWithClient(client)}
+}
+
+// IsPath reports whether path is an s3:// URL this package can handle.
+func IsPath(path string) bool {
+	return strings.HasPrefix(path, Scheme)
+}
+
+// ParsePath splits an s3://bucket/key URL into its bucket and key parts.
+func ParsePath(path string) (bucket, key string, err error) {
+	if !IsPath(path) {
+		return "", "", fmt.Errorf("s3fs: not an s3:// path: %q", path)
+	}
+
+	rest := strings.TrimPrefix(path, Scheme)
+	idx := strings.Index(rest, "/")
+	if idx < 0 {
+		return rest, "", nil
+	}
+
+	return rest[:idx], rest[idx+1:], nil
+}
+
+// Open returns a reader for the object at path.
+func (fs *FS) Open(path string) (io.ReadCloser, error) {
+	bucket, key, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := fs.client.GetObject(&s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Body, nil
+}
+
+// Create returns a writer that uploads everything written to it to path
+// as a single object once Close is called.
+func (fs *FS) Create(path string) (io.WriteCloser, error) {
+	bucket, key, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r, w := io.Pipe()
+	errCh := make(chan error, 1)
+
+	// s3.PutObjectInput.Body is an io.ReadSeeker, which a pipe can't
+	// satisfy; s3manager.Uploader accepts a plain io.Reader and takes
+	// care of buffering/multipart-ing it as needed.
+	go func() {
+		_, err := fs.uploader.Upload(&s3manager.UploadInput{
+			Bucket: &bucket,
+			Key:    &key,
+			Body:   r,
+		})
+		r.CloseWithError(err)
+		errCh <- err
+	}()
+
+	return &writer{w: w, errCh: errCh}, nil
+}
+
+type writer struct {
+	w     *io.PipeWriter
+	errCh chan error
+}
+
+func (f *writer) Write(p []byte) (int, error) {
+	return f.w.Write(p)
+}
+
+func (f *writer) Close() error {
+	if err := f.w.Close(); err != nil {
+		return err
+	}
+	return <-f.errCh
+}
+
+// Stat returns file info for the object at path. Only Name, Size and
+// ModTime are populated.
+func (fs *FS) Stat(path string) (os.FileInfo, error) {
+	bucket, key, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := fs.client.HeadObject(&s3.HeadObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileInfo{name: key, size: aws.Int64Value(out.ContentLength), modTime: aws.TimeValue(out.LastModified)}, nil
+}
+
+// Remove deletes the object at path.
+func (fs *FS) Remove(path string) error {
+	bucket, key, err := ParsePath(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = fs.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	return err
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) Mode() os.FileMode  { return 0o644 }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return false }
+func (fi *fileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,41 @@
+package s3fs
+
+import "testing"
+
+func TestParsePath(t *testing.T) {
+	cases := []struct {
+		path       string
+		wantBucket string
+		wantKey    string
+		wantErr    bool
+	}{
+		{path: "s3://bucket/key/path.txt", wantBucket: "bucket", wantKey: "key/path.txt"},
+		{path: "s3://bucket", wantBucket: "bucket", wantKey: ""},
+		{path: "/local/path", wantErr: true},
+	}
+
+	for _, c := range cases {
+		bucket, key, err := ParsePath(c.path)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("ParsePath(%q): expected error, got none", c.path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("ParsePath(%q): unexpected error: %v", c.path, err)
+		}
+		if bucket != c.wantBucket || key != c.wantKey {
+			t.Errorf("ParsePath(%q) = (%q, %q), want (%q, %q)", c.path, bucket, key, c.wantBucket, c.wantKey)
+		}
+	}
+}
+
+func TestIsPath(t *testing.T) {
+	if !IsPath("s3://bucket/key") {
+		t.Error("expected s3:// URL to be recognised")
+	}
+	if IsPath("/local/path") {
+		t.Error("expected local path to not be recognised")
+	}
+}
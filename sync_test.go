@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveKeyMatchesSyncPrefix(t *testing.T) {
+	// listRemoteETags must be called with a prefix that shares the same
+	// leading "/" shape as the keys resolveKey produces for uploads, or
+	// sync's list-then-skip/delete logic never matches anything real.
+	target := "builds/123"
+	stripPrefix := "dist/"
+
+	uploadKey := resolveKey(target, "dist/app.js", stripPrefix)
+	syncPrefix := resolveKey(target, "", stripPrefix)
+
+	if syncPrefix != "/builds/123" {
+		t.Fatalf("resolveKey(target, \"\", stripPrefix) = %q, want %q", syncPrefix, "/builds/123")
+	}
+
+	if len(uploadKey) < len(syncPrefix) || uploadKey[:len(syncPrefix)] != syncPrefix {
+		t.Errorf("uploaded key %q does not start with sync prefix %q", uploadKey, syncPrefix)
+	}
+}
+
+func TestLocalETagSinglePart(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(path, []byte("hello world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// md5("hello world") = 5eb63bbbe01eeed093cb22bb8f5acdc3
+	etag, err := localETag(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"5eb63bbbe01eeed093cb22bb8f5acdc3"`; etag != want {
+		t.Errorf("localETag() = %q, want %q", etag, want)
+	}
+}
+
+func TestLocalETagMultipart(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.bin")
+	data := make([]byte, 10)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	etag, err := localETag(path, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 3 parts (4, 4, 2 bytes) -> "-3" inside the closing quote identifies a
+	// multipart ETag, as S3 reports it.
+	if want := `-3"`; etag[len(etag)-len(want):] != want {
+		t.Errorf("localETag() = %q, want suffix %q", etag, want)
+	}
+}
+
+func TestOrphanedKeysPreservesExcludedLocalFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "app.log"), []byte("log"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	exclude := []string{filepath.Join(dir, "*.log")}
+	excludedLocal, err := excludedMatches(exclude)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(excludedLocal) != 1 {
+		t.Fatalf("excludedMatches() = %v, want 1 match", excludedLocal)
+	}
+
+	target := "builds/123"
+	excludedKey := resolveKey(target, excludedLocal[0], dir+"/")
+	staleKey := resolveKey(target, "removed.txt", "")
+
+	// as Exec does for Sync+Delete: uploadedKeys gets every matched local file,
+	// excludedMatches keys are added on top so Exclude doesn't cause deletion.
+	keep := map[string]bool{excludedKey: true}
+
+	remote := map[string]string{
+		excludedKey: `"abc"`,
+		staleKey:    `"def"`,
+	}
+
+	orphaned := orphanedKeys(remote, keep)
+	if len(orphaned) != 1 || orphaned[0] != staleKey {
+		t.Errorf("orphanedKeys() = %v, want only %q (excluded file %q must be preserved)", orphaned, staleKey, excludedKey)
+	}
+}
+
+func TestUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(path, []byte("hello world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	etag, err := localETag(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !unchanged(path, etag, 0) {
+		t.Error("unchanged() = false for matching ETag, want true")
+	}
+	if unchanged(path, `"deadbeef"`, 0) {
+		t.Error("unchanged() = true for mismatched ETag, want false")
+	}
+	if unchanged(path, "", 0) {
+		t.Error("unchanged() = true for empty remote ETag, want false")
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultPartSize is used when Plugin.PartSize is unset.
+	defaultPartSize = 64 * 1024 * 1024
+
+	// defaultConcurrency is used when Plugin.Concurrency is unset.
+	defaultConcurrency = 4
+
+	// defaultMaxRetries is used when Plugin.MaxRetries is unset.
+	defaultMaxRetries = 3
+
+	// minPartSize is the smallest part size S3 accepts for multipart uploads,
+	// other than the final part.
+	minPartSize = 5 * 1024 * 1024
+
+	// stateFileSuffix is appended to the source path to derive the path of
+	// the resumable upload state file.
+	stateFileSuffix = ".drone-s3-upload.json"
+)
+
+// uploadState is persisted next to the source file so that a failed
+// multipart upload can be resumed by a subsequent build instead of
+// restarting from zero.
+type uploadState struct {
+	Bucket   string         `json:"bucket"`
+	Key      string         `json:"key"`
+	UploadID string         `json:"upload_id"`
+	PartSize int64          `json:"part_size"`
+	Parts    []*s3.CompletedPart `json:"parts"`
+}
+
+// partSize returns the configured part size, or defaultPartSize if unset.
+func (p *Plugin) partSize() int64 {
+	if p.PartSize > 0 {
+		return p.PartSize
+	}
+	return defaultPartSize
+}
+
+// validatePartSize fails fast, before any part is uploaded, if PartSize is
+// set below what S3 accepts for a non-final multipart part.
+func (p *Plugin) validatePartSize() error {
+	if p.PartSize > 0 && p.PartSize < minPartSize {
+		return fmt.Errorf("uploader: PartSize (%d) is below the S3 multipart minimum of %d bytes", p.PartSize, minPartSize)
+	}
+	return nil
+}
+
+// concurrency returns the configured concurrency, or defaultConcurrency if unset.
+func (p *Plugin) concurrency() int {
+	if p.Concurrency > 0 {
+		return p.Concurrency
+	}
+	return defaultConcurrency
+}
+
+// maxRetries returns the configured retry count, or defaultMaxRetries if unset.
+func (p *Plugin) maxRetries() int {
+	if p.MaxRetries > 0 {
+		return p.MaxRetries
+	}
+	return defaultMaxRetries
+}
+
+// uploadFile uploads the file at src to bucket/key, using a multipart,
+// resumable transfer when the file is larger than the configured part size
+// and a plain PutObject otherwise. It returns the resulting object's ETag.
+func (p *Plugin) uploadFile(client s3iface.S3API, src, key string, putObjectInput *s3.PutObjectInput) (string, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+
+	if info.Size() <= p.partSize() {
+		f, err := os.Open(src)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+
+		putObjectInput.Body = f
+		if p.ChecksumAlgorithm != "" {
+			sum, err := checksumFile(src, p.ChecksumAlgorithm)
+			if err != nil {
+				return "", err
+			}
+			applyChecksum(putObjectInput, p.ChecksumAlgorithm, sum)
+		} else {
+			sum, err := checksumFile(src, "MD5")
+			if err != nil {
+				return "", err
+			}
+			putObjectInput.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(sum))
+		}
+
+		out, err := client.PutObject(putObjectInput)
+		if err != nil {
+			return "", err
+		}
+		return aws.StringValue(out.ETag), nil
+	}
+
+	return p.uploadMultipart(client, src, key, putObjectInput, info.Size())
+}
+
+// uploadMultipart streams src to bucket/key as a multipart upload, computing
+// a per-part checksum, retrying failed parts with backoff, and persisting
+// progress to a state file so the upload can resume after a crash. It
+// returns the resulting object's ETag.
+func (p *Plugin) uploadMultipart(client s3iface.S3API, src, key string, putObjectInput *s3.PutObjectInput, size int64) (string, error) {
+	statePath := src + stateFileSuffix
+	partSize := p.partSize()
+
+	state, err := loadUploadState(statePath)
+	if err != nil {
+		return "", err
+	}
+
+	var uploadID string
+	done := map[int64]*s3.CompletedPart{}
+
+	if state != nil && state.Bucket == p.Bucket && state.Key == key && state.PartSize == partSize {
+		uploadID = state.UploadID
+		for _, part := range state.Parts {
+			done[*part.PartNumber] = part
+		}
+		log.WithFields(log.Fields{"key": key, "uploadId": uploadID}).Info("Resuming multipart upload")
+	} else {
+		create := &s3.CreateMultipartUploadInput{
+			Bucket:      putObjectInput.Bucket,
+			Key:         putObjectInput.Key,
+			ACL:         putObjectInput.ACL,
+			ContentType: putObjectInput.ContentType,
+		}
+		if p.ChecksumAlgorithm != "" {
+			create.ChecksumAlgorithm = aws.String(p.ChecksumAlgorithm)
+		}
+		if putObjectInput.ContentEncoding != nil {
+			create.ContentEncoding = putObjectInput.ContentEncoding
+		}
+		if putObjectInput.CacheControl != nil {
+			create.CacheControl = putObjectInput.CacheControl
+		}
+		if putObjectInput.ServerSideEncryption != nil {
+			create.ServerSideEncryption = putObjectInput.ServerSideEncryption
+		}
+		if putObjectInput.StorageClass != nil {
+			create.StorageClass = putObjectInput.StorageClass
+		}
+		if putObjectInput.SSEKMSKeyId != nil {
+			create.SSEKMSKeyId = putObjectInput.SSEKMSKeyId
+		}
+		if putObjectInput.SSECustomerAlgorithm != nil {
+			create.SSECustomerAlgorithm = putObjectInput.SSECustomerAlgorithm
+			create.SSECustomerKey = putObjectInput.SSECustomerKey
+			create.SSECustomerKeyMD5 = putObjectInput.SSECustomerKeyMD5
+		}
+
+		out, err := client.CreateMultipartUpload(create)
+		if err != nil {
+			return "", err
+		}
+		uploadID = *out.UploadId
+
+		state = &uploadState{Bucket: p.Bucket, Key: key, UploadID: uploadID, PartSize: partSize}
+		if err := saveUploadState(statePath, state); err != nil {
+			log.WithError(err).Warn("Could not persist multipart upload state")
+		}
+	}
+
+	numParts := (size + partSize - 1) / partSize
+	if numParts == 0 {
+		numParts = 1
+	}
+
+	type result struct {
+		part *s3.CompletedPart
+		err  error
+	}
+
+	sem := make(chan struct{}, p.concurrency())
+	results := make(chan result, numParts)
+	var pending int
+
+	for i := int64(0); i < numParts; i++ {
+		partNumber := i + 1
+		if _, ok := done[partNumber]; ok {
+			continue
+		}
+
+		offset := i * partSize
+		length := partSize
+		if offset+length > size {
+			length = size - offset
+		}
+
+		pending++
+		sem <- struct{}{}
+		go func(partNumber int64, offset, length int64) {
+			defer func() { <-sem }()
+			part, err := p.uploadPartWithRetry(client, src, uploadID, putObjectInput, offset, length, partNumber)
+			results <- result{part: part, err: err}
+		}(partNumber, offset, length)
+	}
+
+	var firstErr error
+	for i := 0; i < pending; i++ {
+		r := <-results
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			continue
+		}
+		done[*r.part.PartNumber] = r.part
+		state.Parts = append(state.Parts, r.part)
+		_ = saveUploadState(statePath, state)
+	}
+
+	if firstErr != nil {
+		log.WithFields(log.Fields{"key": key, "uploadId": uploadID, "error": firstErr}).
+			Error("Multipart upload failed, state saved for resume")
+		return "", firstErr
+	}
+
+	parts := make([]*s3.CompletedPart, 0, len(done))
+	for _, part := range done {
+		parts = append(parts, part)
+	}
+	sortCompletedParts(parts)
+
+	complete, err := client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          putObjectInput.Bucket,
+		Key:             putObjectInput.Key,
+		UploadId:        &uploadID,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	os.Remove(statePath)
+	return aws.StringValue(complete.ETag), nil
+}
+
+func (p *Plugin) uploadPartWithRetry(client s3iface.S3API, src, uploadID string, putObjectInput *s3.PutObjectInput, offset, length, partNumber int64) (*s3.CompletedPart, error) {
+	var lastErr error
+	for attempt := 0; attempt <= p.maxRetries(); attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff(attempt))
+		}
+
+		part, err := p.uploadPart(client, src, uploadID, putObjectInput, offset, length, partNumber)
+		if err == nil {
+			return part, nil
+		}
+		lastErr = err
+
+		if reqErr, ok := err.(awserr.Error); ok && !isRetryable(reqErr) {
+			break
+		}
+
+		log.WithFields(log.Fields{
+			"key":        *putObjectInput.Key,
+			"partNumber": partNumber,
+			"attempt":    attempt + 1,
+			"error":      err,
+		}).Warn("Retrying failed part upload")
+	}
+	return nil, lastErr
+}
+
+func (p *Plugin) uploadPart(client s3iface.S3API, src, uploadID string, putObjectInput *s3.PutObjectInput, offset, length, partNumber int64) (*s3.CompletedPart, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := io.NewSectionReader(f, offset, length)
+
+	input := &s3.UploadPartInput{
+		Bucket:     putObjectInput.Bucket,
+		Key:        putObjectInput.Key,
+		UploadId:   &uploadID,
+		PartNumber: aws.Int64(partNumber),
+		Body:       reader,
+	}
+	if putObjectInput.SSECustomerAlgorithm != nil {
+		input.SSECustomerAlgorithm = putObjectInput.SSECustomerAlgorithm
+		input.SSECustomerKey = putObjectInput.SSECustomerKey
+		input.SSECustomerKeyMD5 = putObjectInput.SSECustomerKeyMD5
+	}
+
+	if p.ChecksumAlgorithm != "" {
+		sum, err := checksumReader(io.NewSectionReader(f, offset, length), p.ChecksumAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		applyPartChecksum(input, p.ChecksumAlgorithm, sum)
+	} else {
+		sum, err := checksumReader(io.NewSectionReader(f, offset, length), "MD5")
+		if err != nil {
+			return nil, err
+		}
+		input.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(sum))
+	}
+
+	out, err := client.UploadPart(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3.CompletedPart{ETag: out.ETag, PartNumber: aws.Int64(partNumber)}, nil
+}
+
+// checksumFile hashes the whole file with the given algorithm (MD5, SHA256 or CRC32C).
+func checksumFile(path, algorithm string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return checksumReader(f, algorithm)
+}
+
+func checksumReader(r io.Reader, algorithm string) ([]byte, error) {
+	var h hash.Hash
+	switch algorithm {
+	case "SHA256":
+		h = sha256.New()
+	case "CRC32C":
+		h = crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case "MD5", "":
+		h = md5.New()
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+func applyChecksum(input *s3.PutObjectInput, algorithm string, sum []byte) {
+	encoded := base64.StdEncoding.EncodeToString(sum)
+	switch algorithm {
+	case "SHA256":
+		input.ChecksumSHA256 = aws.String(encoded)
+	case "CRC32C":
+		input.ChecksumCRC32C = aws.String(encoded)
+	default:
+		input.ContentMD5 = aws.String(encoded)
+	}
+}
+
+func applyPartChecksum(input *s3.UploadPartInput, algorithm string, sum []byte) {
+	encoded := base64.StdEncoding.EncodeToString(sum)
+	switch algorithm {
+	case "SHA256":
+		input.ChecksumSHA256 = aws.String(encoded)
+	case "CRC32C":
+		input.ChecksumCRC32C = aws.String(encoded)
+	default:
+		input.ContentMD5 = aws.String(encoded)
+	}
+}
+
+func sortCompletedParts(parts []*s3.CompletedPart) {
+	for i := 1; i < len(parts); i++ {
+		for j := i; j > 0 && *parts[j-1].PartNumber > *parts[j].PartNumber; j-- {
+			parts[j-1], parts[j] = parts[j], parts[j-1]
+		}
+	}
+}
+
+func isRetryable(err awserr.Error) bool {
+	switch err.Code() {
+	case "RequestTimeout", "InternalError", "SlowDown", "ServiceUnavailable":
+		return true
+	default:
+		return false
+	}
+}
+
+func backoff(attempt int) time.Duration {
+	return time.Duration(attempt) * 500 * time.Millisecond
+}
+
+func loadUploadState(path string) (*uploadState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var state uploadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.WithError(err).Warn("Ignoring corrupt multipart upload state file")
+		return nil, nil
+	}
+	return &state, nil
+}
+
+func saveUploadState(path string, state *uploadState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
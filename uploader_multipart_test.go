@@ -0,0 +1,350 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// mockS3Client is a minimal s3iface.S3API double covering only the calls
+// uploadFile/uploadMultipart/uploadPart make. Embedding the interface with
+// a nil value lets it satisfy s3iface.S3API without stubbing every method;
+// any method not overridden below panics if a test calls it unexpectedly.
+type mockS3Client struct {
+	s3iface.S3API
+
+	putObjectFn  func(*s3.PutObjectInput) (*s3.PutObjectOutput, error)
+	createFn     func(*s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error)
+	uploadPartFn func(*s3.UploadPartInput) (*s3.UploadPartOutput, error)
+	completeFn   func(*s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error)
+
+	mu             sync.Mutex
+	createCalls    int
+	uploadPartHits map[int64]int
+}
+
+func (m *mockS3Client) PutObject(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	return m.putObjectFn(in)
+}
+
+func (m *mockS3Client) CreateMultipartUpload(in *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+	m.mu.Lock()
+	m.createCalls++
+	m.mu.Unlock()
+	return m.createFn(in)
+}
+
+func (m *mockS3Client) UploadPart(in *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+	m.mu.Lock()
+	if m.uploadPartHits == nil {
+		m.uploadPartHits = map[int64]int{}
+	}
+	m.uploadPartHits[*in.PartNumber]++
+	m.mu.Unlock()
+	return m.uploadPartFn(in)
+}
+
+func (m *mockS3Client) CompleteMultipartUpload(in *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+	return m.completeFn(in)
+}
+
+func TestUploadFileSmallDefaultsToContentMD5(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "small.txt")
+	if err := os.WriteFile(src, []byte("hello world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var gotContentMD5 string
+	mock := &mockS3Client{
+		putObjectFn: func(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+			gotContentMD5 = aws.StringValue(in.ContentMD5)
+			return &s3.PutObjectOutput{ETag: aws.String(`"etag-small"`)}, nil
+		},
+	}
+
+	p := &Plugin{}
+	etag, err := p.uploadFile(mock, src, "/small.txt", &s3.PutObjectInput{Bucket: aws.String("b"), Key: aws.String("/small.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag != `"etag-small"` {
+		t.Errorf("uploadFile() etag = %q, want %q", etag, `"etag-small"`)
+	}
+	if gotContentMD5 == "" {
+		t.Error("expected uploadFile to set ContentMD5 by default, got none")
+	}
+}
+
+func TestUploadMultipartHappyPath(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "big.bin")
+	if err := os.WriteFile(src, make([]byte, 15), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mock := &mockS3Client{
+		createFn: func(*s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+			return &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload-1")}, nil
+		},
+		uploadPartFn: func(in *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+			return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf("\"part-%d\"", aws.Int64Value(in.PartNumber)))}, nil
+		},
+		completeFn: func(in *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+			parts := in.MultipartUpload.Parts
+			if len(parts) != 3 {
+				t.Errorf("CompleteMultipartUpload got %d parts, want 3", len(parts))
+			}
+			for i, part := range parts {
+				if *part.PartNumber != int64(i+1) {
+					t.Errorf("parts[%d].PartNumber = %d, want %d (parts must be sorted)", i, *part.PartNumber, i+1)
+				}
+			}
+			return &s3.CompleteMultipartUploadOutput{ETag: aws.String(`"final-etag"`)}, nil
+		},
+	}
+
+	p := &Plugin{PartSize: 5, Bucket: "bucket"}
+	input := &s3.PutObjectInput{Bucket: aws.String("bucket"), Key: aws.String("/big.bin")}
+
+	etag, err := p.uploadMultipart(mock, src, "/big.bin", input, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag != `"final-etag"` {
+		t.Errorf("uploadMultipart() etag = %q, want %q", etag, `"final-etag"`)
+	}
+
+	if _, err := os.Stat(src + stateFileSuffix); !os.IsNotExist(err) {
+		t.Error("expected upload state file to be removed after a successful upload")
+	}
+}
+
+func TestUploadMultipartResumesFromSavedState(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "resume.bin")
+	// part size deliberately small so the 11-byte file needs 3 parts (5, 5, 1).
+	const partSize = 5
+	if err := os.WriteFile(src, make([]byte, 11), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Plugin{PartSize: partSize, Bucket: "bucket"}
+	input := &s3.PutObjectInput{Bucket: aws.String("bucket"), Key: aws.String("/resume.bin")}
+
+	state := &uploadState{
+		Bucket:   "bucket",
+		Key:      "/resume.bin",
+		UploadID: "upload-resumed",
+		PartSize: partSize,
+		Parts:    []*s3.CompletedPart{{PartNumber: aws.Int64(1), ETag: aws.String(`"part-1"`)}},
+	}
+	if err := saveUploadState(src+stateFileSuffix, state); err != nil {
+		t.Fatal(err)
+	}
+
+	mock := &mockS3Client{
+		createFn: func(*s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+			t.Error("CreateMultipartUpload should not be called when resuming a saved upload")
+			return nil, fmt.Errorf("unexpected CreateMultipartUpload call")
+		},
+		uploadPartFn: func(in *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+			if *in.PartNumber == 1 {
+				t.Error("part 1 was already completed and should not be re-uploaded")
+			}
+			return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf("\"part-%d\"", aws.Int64Value(in.PartNumber)))}, nil
+		},
+		completeFn: func(in *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+			if len(in.MultipartUpload.Parts) != 3 {
+				t.Errorf("CompleteMultipartUpload got %d parts, want 3", len(in.MultipartUpload.Parts))
+			}
+			return &s3.CompleteMultipartUploadOutput{ETag: aws.String(`"final-etag"`)}, nil
+		},
+	}
+
+	if _, err := p.uploadMultipart(mock, src, "/resume.bin", input, 11); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.mu.Lock()
+	defer mock.mu.Unlock()
+	if mock.uploadPartHits[1] != 0 {
+		t.Errorf("part 1 was re-uploaded %d times, want 0", mock.uploadPartHits[1])
+	}
+}
+
+func TestUploadMultipartIgnoresStateWithMismatchedPartSize(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "resume.bin")
+	if err := os.WriteFile(src, make([]byte, 11), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// state file was saved with a different PartSize than this run is using,
+	// so it must not be resumed - a fresh multipart upload should start.
+	state := &uploadState{
+		Bucket:   "bucket",
+		Key:      "/resume.bin",
+		UploadID: "stale-upload",
+		PartSize: 999,
+		Parts:    []*s3.CompletedPart{{PartNumber: aws.Int64(1), ETag: aws.String(`"stale-part-1"`)}},
+	}
+	if err := saveUploadState(src+stateFileSuffix, state); err != nil {
+		t.Fatal(err)
+	}
+
+	var created bool
+	mock := &mockS3Client{
+		createFn: func(*s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+			created = true
+			return &s3.CreateMultipartUploadOutput{UploadId: aws.String("fresh-upload")}, nil
+		},
+		uploadPartFn: func(in *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+			return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf("\"part-%d\"", aws.Int64Value(in.PartNumber)))}, nil
+		},
+		completeFn: func(in *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+			return &s3.CompleteMultipartUploadOutput{ETag: aws.String(`"final-etag"`)}, nil
+		},
+	}
+
+	p := &Plugin{PartSize: 5, Bucket: "bucket"}
+	input := &s3.PutObjectInput{Bucket: aws.String("bucket"), Key: aws.String("/resume.bin")}
+
+	if _, err := p.uploadMultipart(mock, src, "/resume.bin", input, 11); err != nil {
+		t.Fatal(err)
+	}
+	if !created {
+		t.Error("expected a fresh CreateMultipartUpload when the saved state's PartSize does not match")
+	}
+}
+
+func TestUploadPartWithRetryRecoversFromRetryableError(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "part.bin")
+	if err := os.WriteFile(src, make([]byte, 5), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var attempts int
+	mock := &mockS3Client{
+		uploadPartFn: func(in *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+			attempts++
+			if attempts == 1 {
+				return nil, awserr.New("SlowDown", "slow down", nil)
+			}
+			return &s3.UploadPartOutput{ETag: aws.String(`"part-1"`)}, nil
+		},
+	}
+
+	p := &Plugin{MaxRetries: 1}
+	input := &s3.PutObjectInput{Bucket: aws.String("bucket"), Key: aws.String("/part.bin")}
+
+	part, err := p.uploadPartWithRetry(mock, src, "upload-1", input, 0, 5, 1)
+	if err != nil {
+		t.Fatalf("uploadPartWithRetry() unexpected error: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("uploadPartFn called %d times, want 2 (1 failure + 1 retry)", attempts)
+	}
+	if aws.StringValue(part.ETag) != `"part-1"` {
+		t.Errorf("part ETag = %q, want %q", aws.StringValue(part.ETag), `"part-1"`)
+	}
+}
+
+func TestUploadPartWithRetryGivesUpOnNonRetryableError(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "part.bin")
+	if err := os.WriteFile(src, make([]byte, 5), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var attempts int
+	mock := &mockS3Client{
+		uploadPartFn: func(in *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+			attempts++
+			return nil, awserr.New("AccessDenied", "denied", nil)
+		},
+	}
+
+	p := &Plugin{MaxRetries: 3}
+	input := &s3.PutObjectInput{Bucket: aws.String("bucket"), Key: aws.String("/part.bin")}
+
+	if _, err := p.uploadPartWithRetry(mock, src, "upload-1", input, 0, 5, 1); err == nil {
+		t.Error("expected an error for a non-retryable failure, got none")
+	}
+	if attempts != 1 {
+		t.Errorf("uploadPartFn called %d times, want 1 (non-retryable errors must not be retried)", attempts)
+	}
+}
+
+func TestSortCompletedParts(t *testing.T) {
+	parts := []*s3.CompletedPart{
+		{PartNumber: aws.Int64(3)},
+		{PartNumber: aws.Int64(1)},
+		{PartNumber: aws.Int64(2)},
+	}
+	sortCompletedParts(parts)
+	for i, part := range parts {
+		if *part.PartNumber != int64(i+1) {
+			t.Errorf("parts[%d].PartNumber = %d, want %d", i, *part.PartNumber, i+1)
+		}
+	}
+}
+
+func TestUploadStateRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	want := &uploadState{
+		Bucket:   "bucket",
+		Key:      "/big.bin",
+		UploadID: "upload-1",
+		PartSize: minPartSize,
+		Parts:    []*s3.CompletedPart{{PartNumber: aws.Int64(1), ETag: aws.String(`"part-1"`)}},
+	}
+	if err := saveUploadState(path, want); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := loadUploadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.UploadID != want.UploadID || got.PartSize != want.PartSize || len(got.Parts) != 1 {
+		t.Errorf("loadUploadState() = %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadUploadStateMissingFile(t *testing.T) {
+	dir := t.TempDir()
+	state, err := loadUploadState(filepath.Join(dir, "missing.json"))
+	if err != nil {
+		t.Fatalf("unexpected error for a missing state file: %v", err)
+	}
+	if state != nil {
+		t.Errorf("loadUploadState() = %+v, want nil for a missing file", state)
+	}
+}
+
+func TestLoadUploadStateCorruptFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "corrupt.json")
+	if err := os.WriteFile(path, []byte("{not valid json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := loadUploadState(path)
+	if err != nil {
+		t.Fatalf("expected a corrupt state file to be ignored rather than returned as an error, got: %v", err)
+	}
+	if state != nil {
+		t.Errorf("loadUploadState() = %+v, want nil for a corrupt file", state)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"hash/crc32"
+	"testing"
+)
+
+func TestChecksumReader(t *testing.T) {
+	data := []byte("the quick brown fox jumps over the lazy dog")
+
+	cases := []struct {
+		algorithm string
+		want      []byte
+	}{
+		{algorithm: "MD5", want: md5Sum(data)},
+		{algorithm: "", want: md5Sum(data)},
+		{algorithm: "SHA256", want: sha256Sum(data)},
+		{algorithm: "CRC32C", want: crc32cSum(data)},
+	}
+
+	for _, c := range cases {
+		got, err := checksumReader(bytes.NewReader(data), c.algorithm)
+		if err != nil {
+			t.Fatalf("checksumReader(%q): unexpected error: %v", c.algorithm, err)
+		}
+		if !bytes.Equal(got, c.want) {
+			t.Errorf("checksumReader(%q) = %x (len %d), want %x (len %d)", c.algorithm, got, len(got), c.want, len(c.want))
+		}
+	}
+
+	if _, err := checksumReader(bytes.NewReader(data), "BOGUS"); err == nil {
+		t.Error("checksumReader(\"BOGUS\") expected an error, got none")
+	}
+}
+
+func TestValidatePartSize(t *testing.T) {
+	cases := []struct {
+		name    string
+		size    int64
+		wantErr bool
+	}{
+		{name: "unset", size: 0, wantErr: false},
+		{name: "above minimum", size: minPartSize + 1, wantErr: false},
+		{name: "exactly minimum", size: minPartSize, wantErr: false},
+		{name: "below minimum", size: minPartSize - 1, wantErr: true},
+	}
+
+	for _, c := range cases {
+		p := &Plugin{PartSize: c.size}
+		err := p.validatePartSize()
+		if c.wantErr && err == nil {
+			t.Errorf("%s: expected an error, got none", c.name)
+		}
+		if !c.wantErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+		}
+	}
+}
+
+func md5Sum(data []byte) []byte {
+	sum := md5.Sum(data)
+	return sum[:]
+}
+
+func sha256Sum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+func crc32cSum(data []byte) []byte {
+	table := crc32.MakeTable(crc32.Castagnoli)
+	sum := crc32.Checksum(data, table)
+	return []byte{byte(sum >> 24), byte(sum >> 16), byte(sum >> 8), byte(sum)}
+}